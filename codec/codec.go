@@ -0,0 +1,129 @@
+// Package codec implements the pluggable batch-payload compression used by
+// add_batch and poll, so a high-throughput producer or consumer can trade
+// CPU for less data over the wire.
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Name identifies a Codec on the wire, e.g. as the "codec" field of a
+// compressed poll_ok.
+type Name string
+
+const (
+	// None is the identity codec: payloads pass through unchanged. It is
+	// always supported, and is what a node falls back to for a client that
+	// didn't advertise any codec during its init-time capability handshake.
+	None Name = "none"
+
+	// Snappy compresses with Google's Snappy format.
+	Snappy Name = "snappy"
+
+	// LZ4 compresses with the LZ4 frame format.
+	LZ4 Name = "lz4"
+)
+
+// Codec compresses and decompresses a batch payload.
+type Codec interface {
+	// Name returns the wire discriminator for this codec.
+	Name() Name
+
+	// Encode compresses src.
+	Encode(src []byte) ([]byte, error)
+
+	// Decode restores a payload previously returned by Encode.
+	Decode(src []byte) ([]byte, error)
+}
+
+// Preferred lists the codecs this node will choose between when
+// negotiating compression for poll_ok and add_batch, in order of
+// preference (best compression first). None is always last, since it is
+// the fallback every client supports whether or not it advertised
+// anything.
+var Preferred = []Name{LZ4, Snappy, None}
+
+// Negotiate returns the most preferred codec that also appears in
+// supported, the set a client advertised for itself during init's
+// capability handshake. It falls back to None, which is always "supported"
+// by definition, so a client that didn't opt in at all keeps getting the
+// uncompressed wire format it already expects.
+func Negotiate(supported []Name) Name {
+	want := make(map[Name]bool, len(supported))
+	for _, name := range supported {
+		want[name] = true
+	}
+
+	for _, name := range Preferred {
+		if name == None || want[name] {
+			return name
+		}
+	}
+
+	return None
+}
+
+// ByName returns the Codec registered under name, or an error if name isn't
+// one this node supports.
+func ByName(name Name) (Codec, error) {
+	switch name {
+	case "", None:
+		return noneCodec{}, nil
+	case Snappy:
+		return snappyCodec{}, nil
+	case LZ4:
+		return lz4Codec{}, nil
+	default:
+		return nil, fmt.Errorf("codec: unsupported codec %q", name)
+	}
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() Name                        { return None }
+func (noneCodec) Encode(src []byte) ([]byte, error) { return src, nil }
+func (noneCodec) Decode(src []byte) ([]byte, error) { return src, nil }
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() Name { return Snappy }
+
+func (snappyCodec) Encode(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCodec) Decode(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() Name { return LZ4 }
+
+func (lz4Codec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := lz4.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, fmt.Errorf("codec: lz4 encode: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("codec: lz4 encode: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (lz4Codec) Decode(src []byte) ([]byte, error) {
+	out, err := io.ReadAll(lz4.NewReader(bytes.NewReader(src)))
+	if err != nil {
+		return nil, fmt.Errorf("codec: lz4 decode: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/thtg88/maelstrom-kafka/codec"
+)
+
+// codecCapability remembers the Codec this node negotiated for poll_ok and
+// add_batch payloads during init's capability handshake, so poll and
+// add_batch can compress without the caller having to name a codec on
+// every request. It starts out at codec.None, the always-safe fallback for
+// a client that never advertises anything.
+type codecCapability struct {
+	mutex sync.RWMutex
+	name  codec.Name
+}
+
+func newCodecCapability() *codecCapability {
+	return &codecCapability{name: codec.None}
+}
+
+// Negotiate records the codec chosen for supported, the set of codecs a
+// client advertised support for in its init-time handshake.
+func (c *codecCapability) Negotiate(supported []codec.Name) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.name = codec.Negotiate(supported)
+}
+
+// Codec returns the negotiated Codec, ready to Encode or Decode a payload
+// with.
+func (c *codecCapability) Codec() codec.Codec {
+	c.mutex.RLock()
+	name := c.name
+	c.mutex.RUnlock()
+
+	// name only ever came from codec.Negotiate, so it's always one ByName
+	// recognizes.
+	cd, _ := codec.ByName(name)
+
+	return cd
+}
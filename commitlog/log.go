@@ -0,0 +1,180 @@
+package commitlog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const logFileSuffix = ".log"
+
+// log is the segmented, append-only log for a single key. Older segments
+// are immutable once rolled; only the last one (active) accepts appends.
+type log struct {
+	dir string
+
+	mutex    sync.RWMutex
+	segments []*segment
+	active   *segment
+}
+
+// openLog opens (creating if necessary) the log rooted at dir, recovering
+// any segments already present from a previous run.
+func openLog(dir string) (*log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("commitlog: create log dir: %w", err)
+	}
+
+	baseOffsets, err := existingSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &log{dir: dir}
+
+	for i, baseOffset := range baseOffsets {
+		// Every segment but the last was closed cleanly by a prior rollover,
+		// so only the last one needs crash recovery.
+		s, err := openSegment(dir, baseOffset, i == len(baseOffsets)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		l.segments = append(l.segments, s)
+	}
+
+	if len(l.segments) == 0 {
+		s, err := newSegment(dir, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		l.segments = append(l.segments, s)
+	}
+
+	l.active = l.segments[len(l.segments)-1]
+
+	return l, nil
+}
+
+// existingSegments returns the base offsets of every segment already on
+// disk under dir, in ascending order.
+func existingSegments(dir string) ([]int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("commitlog: list segments: %w", err)
+	}
+
+	var baseOffsets []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, logFileSuffix) {
+			continue
+		}
+
+		baseOffset, err := strconv.ParseInt(strings.TrimSuffix(name, logFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		baseOffsets = append(baseOffsets, baseOffset)
+	}
+
+	sort.Slice(baseOffsets, func(i, j int) bool { return baseOffsets[i] < baseOffsets[j] })
+
+	return baseOffsets, nil
+}
+
+func (l *log) Append(msg int) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.active.full() {
+		s, err := newSegment(l.dir, l.active.nextOffset)
+		if err != nil {
+			return 0, err
+		}
+
+		l.segments = append(l.segments, s)
+		l.active = s
+	}
+
+	return l.active.Append(msg)
+}
+
+func (l *log) ReadFrom(offset int, maxBytes int, maxEntries int) ([]Entry, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	var entries []Entry
+	remainingBytes := maxBytes
+	remainingEntries := maxEntries
+
+	for _, s := range l.segments {
+		if int(s.nextOffset) <= offset {
+			continue
+		}
+
+		segEntries, err := s.ReadFrom(offset, remainingBytes, remainingEntries)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, segEntries...)
+
+		if maxBytes > 0 {
+			remainingBytes -= len(segEntries) * recordBytes
+		}
+		if maxEntries > 0 {
+			remainingEntries -= len(segEntries)
+		}
+
+		if (maxBytes > 0 && remainingBytes <= 0) || (maxEntries > 0 && remainingEntries <= 0) {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// Truncate removes every closed segment whose highest offset is at or below
+// offset. The active segment is never removed, even if offset covers it.
+func (l *log) Truncate(offset int) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	kept := l.segments[:0]
+
+	for _, s := range l.segments {
+		if s != l.active && int(s.nextOffset)-1 <= offset {
+			if err := s.Remove(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		kept = append(kept, s)
+	}
+
+	l.segments = kept
+
+	return nil
+}
+
+func (l *log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var firstErr error
+	for _, s := range l.segments {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
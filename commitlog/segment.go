@@ -0,0 +1,317 @@
+package commitlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+const (
+	// segmentMaxBytes is the on-disk size at which a segment is rolled over
+	// to a new one.
+	segmentMaxBytes = 1 << 20 // 1 MiB
+
+	// recordBytes is the fixed on-disk size of a single log record: an
+	// int64 offset followed by an int64 msg.
+	recordBytes = 16
+
+	// indexEntryBytes is the fixed on-disk size of a single index entry: an
+	// int64 offset followed by an int64 byte position into the segment's
+	// log file.
+	indexEntryBytes = 16
+
+	// indexInterval is how many records are appended between index entries,
+	// keeping the index sparse rather than one entry per record.
+	indexInterval = 8
+
+	indexFileSuffix = ".index"
+)
+
+// segment is one fixed-size chunk of a log: an append-only file of
+// fixed-size records, plus a sparse index file mapping offset to byte
+// position within the log file so reads don't have to scan from the start.
+type segment struct {
+	dir        string
+	baseOffset int64
+	nextOffset int64
+
+	logFile           *os.File
+	size              int64
+	recordsSinceIndex int
+
+	indexFile *os.File
+	indexMmap []byte
+}
+
+func segmentPaths(dir string, baseOffset int64) (logPath, indexPath string) {
+	name := fmt.Sprintf("%020d", baseOffset)
+	return filepath.Join(dir, name+logFileSuffix), filepath.Join(dir, name+indexFileSuffix)
+}
+
+// newSegment creates a brand new, empty segment starting at baseOffset.
+func newSegment(dir string, baseOffset int64) (*segment, error) {
+	logPath, indexPath := segmentPaths(dir, baseOffset)
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("commitlog: create segment log: %w", err)
+	}
+
+	indexFile, err := os.OpenFile(indexPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("commitlog: create segment index: %w", err)
+	}
+
+	return &segment{
+		dir:        dir,
+		baseOffset: baseOffset,
+		nextOffset: baseOffset,
+		logFile:    logFile,
+		indexFile:  indexFile,
+	}, nil
+}
+
+// openSegment reopens a segment that already exists on disk. When recover is
+// true (only ever the last segment in a log), the log file is scanned to
+// rebuild size/nextOffset and any trailing partial record left by a crash is
+// truncated away.
+func openSegment(dir string, baseOffset int64, recover bool) (*segment, error) {
+	logPath, indexPath := segmentPaths(dir, baseOffset)
+
+	logFile, err := os.OpenFile(logPath, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("commitlog: open segment log: %w", err)
+	}
+
+	indexFile, err := os.OpenFile(indexPath, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("commitlog: open segment index: %w", err)
+	}
+
+	info, err := logFile.Stat()
+	if err != nil {
+		logFile.Close()
+		indexFile.Close()
+		return nil, fmt.Errorf("commitlog: stat segment log: %w", err)
+	}
+
+	size := info.Size()
+	if recover {
+		// Drop any trailing partial record left behind by a crash mid-write.
+		size -= size % recordBytes
+		if err := logFile.Truncate(size); err != nil {
+			logFile.Close()
+			indexFile.Close()
+			return nil, fmt.Errorf("commitlog: truncate partial record: %w", err)
+		}
+	}
+
+	s := &segment{
+		dir:        dir,
+		baseOffset: baseOffset,
+		nextOffset: baseOffset + size/recordBytes,
+		logFile:    logFile,
+		size:       size,
+		indexFile:  indexFile,
+	}
+
+	if err := s.mmapIndex(); err != nil {
+		logFile.Close()
+		indexFile.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// mmapIndex (re-)maps the index file into memory, picking up any entries
+// written since the last mapping. Index reads are served from this mapping
+// rather than from syscalls, per the "mmap-based index reads" design.
+func (s *segment) mmapIndex() error {
+	if s.indexMmap != nil {
+		if err := syscall.Munmap(s.indexMmap); err != nil {
+			return fmt.Errorf("commitlog: unmap index: %w", err)
+		}
+		s.indexMmap = nil
+	}
+
+	info, err := s.indexFile.Stat()
+	if err != nil {
+		return fmt.Errorf("commitlog: stat index: %w", err)
+	}
+
+	if info.Size() == 0 {
+		return nil
+	}
+
+	data, err := syscall.Mmap(int(s.indexFile.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("commitlog: mmap index: %w", err)
+	}
+
+	s.indexMmap = data
+
+	return nil
+}
+
+func (s *segment) full() bool {
+	return s.size+recordBytes > segmentMaxBytes
+}
+
+// Append writes msg to the end of the segment and returns its offset.
+func (s *segment) Append(msg int) (int, error) {
+	offset := s.nextOffset
+
+	var record [recordBytes]byte
+	binary.BigEndian.PutUint64(record[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(record[8:16], uint64(msg))
+
+	if _, err := s.logFile.Write(record[:]); err != nil {
+		return 0, fmt.Errorf("commitlog: append record: %w", err)
+	}
+
+	position := s.size
+	s.size += recordBytes
+	s.nextOffset++
+
+	s.recordsSinceIndex++
+	if s.recordsSinceIndex >= indexInterval {
+		if err := s.appendIndexEntry(offset, position); err != nil {
+			return 0, err
+		}
+		s.recordsSinceIndex = 0
+	}
+
+	return int(offset), nil
+}
+
+func (s *segment) appendIndexEntry(offset, position int64) error {
+	var entry [indexEntryBytes]byte
+	binary.BigEndian.PutUint64(entry[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(entry[8:16], uint64(position))
+
+	if _, err := s.indexFile.Write(entry[:]); err != nil {
+		return fmt.Errorf("commitlog: append index entry: %w", err)
+	}
+
+	return s.mmapIndex()
+}
+
+// startPosition returns the byte position to start scanning the log file
+// from in order to find offset, using the sparse index to skip as much of
+// the file as possible.
+func (s *segment) startPosition(offset int64) int64 {
+	entries := len(s.indexMmap) / indexEntryBytes
+	if entries == 0 {
+		return 0
+	}
+
+	// Binary search for the last index entry whose offset is <= the
+	// requested offset.
+	i := sort.Search(entries, func(i int) bool {
+		entryOffset := int64(binary.BigEndian.Uint64(s.indexMmap[i*indexEntryBytes : i*indexEntryBytes+8]))
+		return entryOffset > offset
+	})
+
+	if i == 0 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(s.indexMmap[(i-1)*indexEntryBytes+8 : (i-1)*indexEntryBytes+16]))
+}
+
+// ReadFrom returns every entry in the segment at or above offset. If
+// maxBytes is positive, reading stops once that many record bytes have been
+// read; if maxEntries is positive, reading stops once that many entries have
+// been found. Either bound also limits how much of the segment is read off
+// disk in the first place, so a small maxEntries against a large segment
+// doesn't pull the whole tail into memory just to throw most of it away.
+func (s *segment) ReadFrom(offset int, maxBytes int, maxEntries int) ([]Entry, error) {
+	position := s.startPosition(int64(offset))
+
+	readLen := s.size - position
+
+	if maxBytes > 0 && int64(maxBytes) < readLen {
+		readLen = int64(maxBytes)
+	}
+
+	if maxEntries > 0 {
+		// startPosition can land up to indexInterval-1 records before offset,
+		// so read enough extra to still turn up maxEntries records at or
+		// above offset.
+		if budget := int64(maxEntries+indexInterval) * recordBytes; budget < readLen {
+			readLen = budget
+		}
+	}
+
+	buf := make([]byte, readLen)
+	if _, err := s.logFile.ReadAt(buf, position); err != nil {
+		return nil, fmt.Errorf("commitlog: read segment: %w", err)
+	}
+
+	var entries []Entry
+	for i := 0; i+recordBytes <= len(buf); i += recordBytes {
+		entryOffset := int64(binary.BigEndian.Uint64(buf[i : i+8]))
+		if entryOffset < int64(offset) {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Offset: int(entryOffset),
+			Msg:    int(int64(binary.BigEndian.Uint64(buf[i+8 : i+16]))),
+		})
+
+		if maxBytes > 0 && len(entries)*recordBytes >= maxBytes {
+			break
+		}
+
+		if maxEntries > 0 && len(entries) >= maxEntries {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// Remove closes and deletes the segment's files from disk.
+func (s *segment) Remove() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	logPath, indexPath := segmentPaths(s.dir, s.baseOffset)
+
+	if err := os.Remove(logPath); err != nil {
+		return fmt.Errorf("commitlog: remove segment log: %w", err)
+	}
+
+	if err := os.Remove(indexPath); err != nil {
+		return fmt.Errorf("commitlog: remove segment index: %w", err)
+	}
+
+	return nil
+}
+
+func (s *segment) Close() error {
+	if s.indexMmap != nil {
+		if err := syscall.Munmap(s.indexMmap); err != nil {
+			return fmt.Errorf("commitlog: unmap index: %w", err)
+		}
+		s.indexMmap = nil
+	}
+
+	if err := s.indexFile.Close(); err != nil {
+		return fmt.Errorf("commitlog: close index: %w", err)
+	}
+
+	if err := s.logFile.Close(); err != nil {
+		return fmt.Errorf("commitlog: close log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/thtg88/maelstrom-kafka/commitlog"
+)
+
+// committedOffsetsFile is where diskStore persists the committed offset for
+// each key, so commit_offsets survives a restart alongside the commit log
+// itself.
+const committedOffsetsFile = "committed-offsets.json"
+
+// diskStore is the Store backend for single-node mode: sent messages go
+// through a commitlog.CommitLog so the node survives restarts instead of
+// losing its log to an in-memory map.
+type diskStore struct {
+	log commitlog.CommitLog
+
+	committedOffsetsPath string
+
+	mutex     sync.RWMutex
+	committed map[string]int
+}
+
+func newDiskStore(dataDir string) (*diskStore, error) {
+	cl, err := commitlog.Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &diskStore{
+		log:                  cl,
+		committedOffsetsPath: filepath.Join(dataDir, committedOffsetsFile),
+		committed:            make(map[string]int),
+	}
+
+	if err := s.loadCommittedOffsets(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *diskStore) Send(_ context.Context, key string, msg int) (int, error) {
+	return s.log.Append(key, msg)
+}
+
+// Poll enforces maxBytes across the whole call, the same as memoryStore.Poll
+// does: each key's ReadFrom is capped at whatever budget the keys read
+// before it haven't already spent, not at the full maxBytes independently.
+func (s *diskStore) Poll(offsets map[string]int, maxBytes, maxMsgsPerKey int) map[string][][]int {
+	msgs := make(map[string][][]int)
+
+	remainingBytes := maxBytes
+
+	for key, offset := range offsets {
+		if maxBytes > 0 && remainingBytes <= 0 {
+			break
+		}
+
+		keyMaxBytes := maxBytes
+		if maxBytes > 0 {
+			keyMaxBytes = remainingBytes
+		}
+
+		entries, err := s.log.ReadFrom(key, offset, keyMaxBytes, maxMsgsPerKey)
+		if err != nil {
+			log.Printf("poll %s from %d: %s", key, offset, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			msgs[key] = append(msgs[key], []int{entry.Offset, entry.Msg})
+		}
+
+		remainingBytes -= len(entries) * commitlog.RecordBytes
+	}
+
+	return msgs
+}
+
+func (s *diskStore) CommitOffsets(offsets map[string]int) {
+	s.mutex.Lock()
+
+	// Committing an offset only records that it's been processed; it must
+	// not also reclaim the log itself, or a second consumer (or a replay
+	// from an earlier offset) would find its messages gone. Retention is a
+	// separate concern from commit_offsets and isn't wired up here.
+	for key, offset := range offsets {
+		s.committed[key] = offset
+	}
+
+	snapshot := make(map[string]int, len(s.committed))
+	for key, offset := range s.committed {
+		snapshot[key] = offset
+	}
+
+	s.mutex.Unlock()
+
+	if err := s.saveCommittedOffsets(snapshot); err != nil {
+		log.Printf("persist committed offsets: %s", err)
+	}
+}
+
+func (s *diskStore) ListCommittedOffsets(keys []string) map[string]int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	offsets := make(map[string]int)
+
+	for _, key := range keys {
+		if offset, ok := s.committed[key]; ok {
+			offsets[key] = offset
+		}
+	}
+
+	return offsets
+}
+
+func (s *diskStore) loadCommittedOffsets() error {
+	data, err := os.ReadFile(s.committedOffsetsPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("load committed offsets: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return json.Unmarshal(data, &s.committed)
+}
+
+func (s *diskStore) saveCommittedOffsets(offsets map[string]int) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return fmt.Errorf("marshal committed offsets: %w", err)
+	}
+
+	return os.WriteFile(s.committedOffsetsPath, data, 0o644)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultSessionTimeout is how long a member may go without a heartbeat
+// before expireStale drops it from the group and triggers a rebalance, in
+// the style of Kafka's consumer group session.timeout.ms.
+const defaultSessionTimeout = 10 * time.Second
+
+// member is a single consumer known to the group, identified by the
+// member_id it joined with.
+type member struct {
+	keys          []string
+	lastHeartbeat time.Time
+}
+
+// group is the consumer-group coordinator: it tracks membership and, on
+// every join/leave/expiry, reassigns key ownership across the surviving
+// members and bumps the generation. commit_offsets and poll use the
+// generation to detect a consumer acting on stale ownership, the same way
+// Kafka fences a rebalanced consumer's commits.
+type group struct {
+	mutex sync.Mutex
+
+	sessionTimeout time.Duration
+
+	generation int
+	members    map[string]*member
+	owners     map[string]string // key -> member_id
+}
+
+func newGroup(sessionTimeout time.Duration) *group {
+	return &group{
+		sessionTimeout: sessionTimeout,
+		members:        make(map[string]*member),
+		owners:         make(map[string]string),
+	}
+}
+
+// Generation returns the group's current generation.
+func (g *group) Generation() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.generation
+}
+
+// Join registers memberID as interested in keys, or updates its interest set
+// if it is already a member, and rebalances. It returns the generation the
+// member should sync against.
+func (g *group) Join(memberID string, keys []string) int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.members[memberID] = &member{keys: keys, lastHeartbeat: time.Now()}
+	g.rebalance()
+
+	return g.generation
+}
+
+// Sync returns the keys currently owned by memberID, along with the
+// generation they are owned for. ok is false if memberID is not a known
+// member or generationID is not the group's current generation, in which
+// case the caller has been fenced and should rejoin.
+func (g *group) Sync(memberID string, generationID int) (keys []string, ok bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, known := g.members[memberID]; !known || generationID != g.generation {
+		return nil, false
+	}
+
+	for key, owner := range g.owners {
+		if owner == memberID {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, true
+}
+
+// Heartbeat refreshes memberID's liveness, keeping it from expiring. It
+// returns false if memberID is not a known member or generationID is behind
+// the group's current generation, meaning the caller was fenced by a
+// rebalance it hasn't caught up with yet.
+func (g *group) Heartbeat(memberID string, generationID int) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	m, known := g.members[memberID]
+	if !known || generationID != g.generation {
+		return false
+	}
+
+	m.lastHeartbeat = time.Now()
+
+	return true
+}
+
+// Leave removes memberID from the group and rebalances its keys across the
+// remaining members.
+func (g *group) Leave(memberID string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, known := g.members[memberID]; !known {
+		return
+	}
+
+	delete(g.members, memberID)
+	g.rebalance()
+}
+
+// ExpireStale drops every member whose heartbeat has lapsed past the
+// session timeout and rebalances if any were dropped. It is meant to be
+// polled periodically from a background goroutine, since nothing else
+// prompts the group to notice a member has gone quiet.
+func (g *group) ExpireStale() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	deadline := time.Now().Add(-g.sessionTimeout)
+
+	var expired bool
+	for id, m := range g.members {
+		if m.lastHeartbeat.Before(deadline) {
+			delete(g.members, id)
+			expired = true
+		}
+	}
+
+	if expired {
+		g.rebalance()
+	}
+}
+
+// CheckCommit reports whether generationID is fresh enough to commit
+// offsets. A generationID of 0 means the caller didn't opt into fencing and
+// is always allowed; otherwise the commit is rejected once it is behind the
+// group's current generation.
+func (g *group) CheckCommit(generationID int) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return generationID == 0 || generationID >= g.generation
+}
+
+// rebalance recomputes key ownership, assigning each key round-robin across
+// only the members that declared interest in it, sorted by member_id for
+// determinism, and bumps the generation. A key no member wants is dropped
+// from owners entirely. Callers must hold g.mutex.
+func (g *group) rebalance() {
+	subscribers := make(map[string][]string)
+	for id, m := range g.members {
+		for _, key := range m.keys {
+			subscribers[key] = append(subscribers[key], id)
+		}
+	}
+
+	keys := make([]string, 0, len(subscribers))
+	for key := range subscribers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	owners := make(map[string]string, len(keys))
+	for i, key := range keys {
+		candidates := subscribers[key]
+		sort.Strings(candidates)
+
+		owners[key] = candidates[i%len(candidates)]
+	}
+
+	g.owners = owners
+	g.generation++
+}
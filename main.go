@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
-	"sort"
-	"sync"
+	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/thtg88/maelstrom-kafka/codec"
+	"github.com/thtg88/maelstrom-kafka/tracing"
 )
 
 const (
@@ -21,8 +29,46 @@ const (
 
 	ListCommittedOffsetsType   = "list_committed_offsets"
 	ListCommittedOffsetsOkType = "list_committed_offsets_ok"
+
+	JoinGroupType   = "join_group"
+	JoinGroupOkType = "join_group_ok"
+
+	SyncGroupType   = "sync_group"
+	SyncGroupOkType = "sync_group_ok"
+
+	HeartbeatType   = "heartbeat"
+	HeartbeatOkType = "heartbeat_ok"
+
+	LeaveGroupType   = "leave_group"
+	LeaveGroupOkType = "leave_group_ok"
+
+	AddBatchType   = "add_batch"
+	AddBatchOkType = "add_batch_ok"
 )
 
+// expireStaleInterval is how often the background reaper checks for members
+// whose heartbeat has lapsed, relative to defaultSessionTimeout.
+const expireStaleInterval = 2 * time.Second
+
+// RPC: `init` (extended)
+// maelstrom's own init message only carries node_id/node_ids, but a client
+// built against the codec package can extend its init-time handshake with
+// the codecs it knows how to decode:
+// {
+//   "type": "init",
+//   "node_id": "n1",
+//   "node_ids": ["n1", "n2"],
+//   "supported_codecs": ["snappy", "lz4"]
+// }
+// Once negotiated, the node is free to compress poll_ok and accept a
+// compressed add_batch from that client without being asked again on every
+// request. A client that omits supported_codecs, or whose list shares
+// nothing with codec.Preferred, keeps getting the uncompressed wire format.
+
+type InitCapabilityBody struct {
+	SupportedCodecs []codec.Name `json:"supported_codecs,omitempty"`
+}
+
 // RPC: `send`
 // This message requests that a "msg" value be appended to a log identified by "key".
 // Your node will receive a request message body that looks like this:
@@ -38,14 +84,16 @@ const (
 // }
 
 type SendBody struct {
-	Type string `json:"type"`
-	Key  string `json:"key"`
-	Msg  int    `json:"msg"`
+	Type  string          `json:"type"`
+	Key   string          `json:"key"`
+	Msg   int             `json:"msg"`
+	Trace tracing.Carrier `json:"trace,omitempty"`
 }
 
 type SendOkBody struct {
-	Type   string `json:"type"`
-	Offset int    `json:"offset"`
+	Type   string          `json:"type"`
+	Offset int             `json:"offset"`
+	Trace  tracing.Carrier `json:"trace,omitempty"`
 }
 
 // RPC: `poll`
@@ -67,15 +115,79 @@ type SendOkBody struct {
 //     "k2": [[2000, 7], [2001, 2]]
 //   }
 // }
+//
+// The request can bound how much comes back: max_bytes caps the
+// (approximate) bytes of entries returned across the whole call, and
+// max_msgs_per_key caps the number of entries per key. If nothing new is
+// available above the requested offsets, the handler long-polls for up to
+// max_wait_ms before
+// replying, Kafka-fetch-style, instead of immediately returning an empty
+// poll_ok.
+//
+// If this client negotiated a codec in its init-time handshake, poll_ok
+// carries msgs JSON-encoded and then compressed as an opaque blob instead:
+// {
+//   "type": "poll_ok",
+//   "codec": "lz4",
+//   "blob": "<base64 of the compressed {"k1": [[1000, 9]], ...} JSON>"
+// }
 
 type PollBody struct {
-	Type    string         `json:"type"`
-	Offsets map[string]int `json:"offsets"`
+	Type          string          `json:"type"`
+	Offsets       map[string]int  `json:"offsets"`
+	MaxBytes      int             `json:"max_bytes,omitempty"`
+	MaxMsgsPerKey int             `json:"max_msgs_per_key,omitempty"`
+	MaxWaitMs     int             `json:"max_wait_ms,omitempty"`
+	Trace         tracing.Carrier `json:"trace,omitempty"`
 }
 
 type PollOkBody struct {
 	Type string             `json:"type"`
 	Msgs map[string][][]int `json:"msgs"`
+
+	// Codec names the compression applied to Blob, e.g. "lz4". It is empty
+	// when Msgs carries the payload uncompressed, which is what this
+	// client gets if it never negotiated a codec.
+	Codec codec.Name `json:"codec,omitempty"`
+
+	// Blob is Msgs, JSON-encoded and then compressed with Codec, sent
+	// instead of Msgs once a codec has been negotiated.
+	Blob []byte `json:"blob,omitempty"`
+
+	// GenerationId is the consumer group's generation at the time of this
+	// poll, so a consumer mid-batch can tell whether a rebalance reassigned
+	// the keys it's polling out from under it.
+	GenerationId int             `json:"generation_id,omitempty"`
+	Trace        tracing.Carrier `json:"trace,omitempty"`
+}
+
+// Decode returns b's messages, decompressing Blob with Codec first if the
+// reply carried a compressed payload instead of Msgs directly. Anything
+// that reads a poll_ok - including a peer's own reconcile request - must go
+// through this instead of reading Msgs directly, since whether a given
+// reply is compressed depends on what the requester's node-wide codec
+// negotiation was, not on who's asking.
+func (b PollOkBody) Decode() (map[string][][]int, error) {
+	if len(b.Blob) == 0 {
+		return b.Msgs, nil
+	}
+
+	c, err := codec.ByName(b.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := c.Decode(b.Blob)
+	if err != nil {
+		return nil, fmt.Errorf("poll_ok: decode %s blob: %w", b.Codec, err)
+	}
+
+	var msgs map[string][][]int
+	if err := json.Unmarshal(raw, &msgs); err != nil {
+		return nil, fmt.Errorf("poll_ok: unmarshal decoded msgs: %w", err)
+	}
+
+	return msgs, nil
 }
 
 // RPC: `commit_offsets`
@@ -97,10 +209,18 @@ type PollOkBody struct {
 type CommitOffsetsBody struct {
 	Type    string         `json:"type"`
 	Offsets map[string]int `json:"offsets"`
+
+	// GenerationId fences this commit against the consumer group: if it is
+	// set and behind the group's current generation, the commit is rejected
+	// because a rebalance has already reassigned these keys to someone
+	// else. Zero opts out of fencing entirely.
+	GenerationId int             `json:"generation_id,omitempty"`
+	Trace        tracing.Carrier `json:"trace,omitempty"`
 }
 
 type CommitOffsetsOkBody struct {
-	Type string `json:"type"`
+	Type  string          `json:"type"`
+	Trace tracing.Carrier `json:"trace,omitempty"`
 }
 
 // RPC: `list_committed_offsets`
@@ -122,87 +242,292 @@ type CommitOffsetsOkBody struct {
 // }
 
 type ListCommittedOffsetsBody struct {
-	Type string   `json:"type"`
-	Keys []string `json:"keys"`
+	Type  string          `json:"type"`
+	Keys  []string        `json:"keys"`
+	Trace tracing.Carrier `json:"trace,omitempty"`
 }
 
 type ListCommittedOffsetsOkBody struct {
-	Type    string         `json:"type"`
-	Offsets map[string]int `json:"offsets"`
+	Type    string          `json:"type"`
+	Offsets map[string]int  `json:"offsets"`
+	Trace   tracing.Carrier `json:"trace,omitempty"`
 }
 
-func main() {
-	type logs struct {
-		mutex sync.RWMutex
-
-		// {
-		// 	"k1": 1000,
-		// 	"k2": 2000
-		// }
-		offsets map[string]int
-
-		// {
-		// 	"k1": {
-		// 		0: 1,
-		// 		1: 123,
-		// 		2: 12
-		// 	},
-		// 	"k2": {
-		// 		0: 3,
-		// 		1: 456,
-		// 		2: 45
-		// 	}
-		// }
-		msgs map[string]map[int]int
+// RPC: `join_group`
+// A consumer announces itself to the group and declares which keys it
+// wants to consume. Joining (or re-joining with a different key set)
+// always triggers a rebalance of key ownership across the group.
+// {
+//   "type": "join_group",
+//   "member_id": "c1",
+//   "keys": ["k1", "k2"]
+// }
+// The response carries the generation the member should sync against to
+// learn its assignment:
+// {
+//   "type": "join_group_ok",
+//   "generation_id": 3
+// }
+
+type JoinGroupBody struct {
+	Type     string          `json:"type"`
+	MemberId string          `json:"member_id"`
+	Keys     []string        `json:"keys"`
+	Trace    tracing.Carrier `json:"trace,omitempty"`
+}
+
+type JoinGroupOkBody struct {
+	Type         string          `json:"type"`
+	GenerationId int             `json:"generation_id"`
+	Trace        tracing.Carrier `json:"trace,omitempty"`
+}
+
+// RPC: `sync_group`
+// Having joined, a member calls sync_group to fetch the keys the
+// coordinator assigned it for the given generation.
+// {
+//   "type": "sync_group",
+//   "member_id": "c1",
+//   "generation_id": 3
+// }
+// If generation_id is behind the group's current generation the member has
+// been fenced by a rebalance it hasn't rejoined for yet, and gets back a
+// precondition-failed error instead of an assignment.
+// {
+//   "type": "sync_group_ok",
+//   "keys": ["k1"]
+// }
+
+type SyncGroupBody struct {
+	Type         string          `json:"type"`
+	MemberId     string          `json:"member_id"`
+	GenerationId int             `json:"generation_id"`
+	Trace        tracing.Carrier `json:"trace,omitempty"`
+}
+
+type SyncGroupOkBody struct {
+	Type  string          `json:"type"`
+	Keys  []string        `json:"keys"`
+	Trace tracing.Carrier `json:"trace,omitempty"`
+}
+
+// RPC: `heartbeat`
+// A member pings the coordinator periodically to prove it's still alive.
+// Letting the session timeout lapse without one causes the background
+// reaper to drop the member and rebalance its keys to the rest of the
+// group.
+// {
+//   "type": "heartbeat",
+//   "member_id": "c1",
+//   "generation_id": 3
+// }
+// {
+//   "type": "heartbeat_ok"
+// }
+
+type HeartbeatBody struct {
+	Type         string          `json:"type"`
+	MemberId     string          `json:"member_id"`
+	GenerationId int             `json:"generation_id"`
+	Trace        tracing.Carrier `json:"trace,omitempty"`
+}
+
+type HeartbeatOkBody struct {
+	Type  string          `json:"type"`
+	Trace tracing.Carrier `json:"trace,omitempty"`
+}
+
+// RPC: `leave_group`
+// A member leaving cleanly, e.g. on shutdown, so its keys are reassigned
+// immediately instead of waiting out the session timeout.
+// {
+//   "type": "leave_group",
+//   "member_id": "c1"
+// }
+// {
+//   "type": "leave_group_ok"
+// }
+
+type LeaveGroupBody struct {
+	Type     string          `json:"type"`
+	MemberId string          `json:"member_id"`
+	Trace    tracing.Carrier `json:"trace,omitempty"`
+}
+
+type LeaveGroupOkBody struct {
+	Type  string          `json:"type"`
+	Trace tracing.Carrier `json:"trace,omitempty"`
+}
+
+// BatchEntry is a single (key, msg) pair within an add_batch request or its
+// decompressed payload. It's encoded on the wire as the 2-element array
+// ["k1", 123] rather than an object, the same tuple shape poll_ok already
+// uses for its (offset, msg) pairs.
+type BatchEntry struct {
+	Key string
+	Msg int
+}
+
+func (e BatchEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]interface{}{e.Key, e.Msg})
+}
+
+func (e *BatchEntry) UnmarshalJSON(data []byte) error {
+	var tuple []json.RawMessage
+
+	if err := json.Unmarshal(data, &tuple); err != nil {
+		return err
+	}
+
+	if len(tuple) != 2 {
+		return fmt.Errorf("batch entry: want [key, msg], got %d elements", len(tuple))
+	}
+
+	if err := json.Unmarshal(tuple[0], &e.Key); err != nil {
+		return fmt.Errorf("batch entry: key: %w", err)
+	}
+
+	if err := json.Unmarshal(tuple[1], &e.Msg); err != nil {
+		return fmt.Errorf("batch entry: msg: %w", err)
 	}
 
-	var committed logs
-	var uncommitted logs
+	return nil
+}
+
+// RPC: `add_batch`
+// This message batches multiple `send`s into a single round trip: it
+// requests that every (key, msg) pair in msgs be appended to its log, in
+// order, in one shot. Your node will receive a request message body that
+// looks like this:
+// {
+//   "type": "add_batch",
+//   "msgs": [["k1", 123], ["k1", 124], ["k2", 9]]
+// }
+// A producer that negotiated a codec in its init-time handshake can send
+// msgs compressed instead, the same opaque-blob shape poll_ok uses:
+// {
+//   "type": "add_batch",
+//   "codec": "lz4",
+//   "blob": "<base64 of the compressed [["k1", 123], ...] JSON>"
+// }
+// In response, it should send an acknowledge with an `add_batch_ok` message
+// containing the offset assigned to each entry, in the same order as msgs:
+// {
+//   "type": "add_batch_ok",
+//   "offsets": [1000, 1001, 2000]
+// }
+// Entries are appended one at a time, so an error partway through a batch
+// leaves the earlier entries in the log despite the caller never seeing an
+// add_batch_ok for them; a producer that retries the whole batch on error
+// should expect at-least-once semantics, the same as retrying a `send`.
+
+type AddBatchBody struct {
+	Type  string          `json:"type"`
+	Msgs  []BatchEntry    `json:"msgs,omitempty"`
+	Codec codec.Name      `json:"codec,omitempty"`
+	Blob  []byte          `json:"blob,omitempty"`
+	Trace tracing.Carrier `json:"trace,omitempty"`
+}
 
-	uncommitted.offsets = make(map[string]int)
-	uncommitted.msgs = make(map[string]map[int]int)
+type AddBatchOkBody struct {
+	Type    string          `json:"type"`
+	Offsets []int           `json:"offsets"`
+	Trace   tracing.Carrier `json:"trace,omitempty"`
+}
 
-	committed.offsets = make(map[string]int)
-	committed.msgs = make(map[string]map[int]int)
+func main() {
+	replicated := flag.Bool("replicated", false, "use the replicated, lin-kv-backed store so multiple nodes agree on offsets, instead of the single-node on-disk store")
+	dataDir := flag.String("data-dir", "data", "directory for the on-disk commit log (ignored in --replicated mode)")
+	flag.Parse()
 
 	node := maelstrom.NewNode()
 
-	node.Handle(SendType, func(msg maelstrom.Message) error {
-		var body SendBody
+	var store Store
+	var rs *replicatedStore
 
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+	if *replicated {
+		rs = newReplicatedStore(node)
+		store = rs
+	} else {
+		ds, err := newDiskStore(*dataDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = ds
+	}
+
+	links := newOffsetLinks()
+	grp := newGroup(defaultSessionTimeout)
+	waiter := newPollWaiter()
+	codecCap := newCodecCapability()
+
+	var tracingShutdown func(context.Context) error
+
+	node.Handle("init", func(msg maelstrom.Message) error {
+		var capBody InitCapabilityBody
+		if err := json.Unmarshal(msg.Body, &capBody); err != nil {
 			return err
 		}
 
-		uncommitted.mutex.Lock()
-		defer uncommitted.mutex.Unlock()
+		codecCap.Negotiate(capBody.SupportedCodecs)
 
-		if _, ok := uncommitted.msgs[body.Key]; !ok {
-			uncommitted.msgs[body.Key] = make(map[int]int)
+		if tracing.Enabled() {
+			shutdown, err := tracing.Setup(context.Background(), node.ID())
+			if err != nil {
+				return err
+			}
+
+			tracingShutdown = shutdown
 		}
 
-		if _, ok := uncommitted.offsets[body.Key]; !ok {
-			uncommitted.offsets[body.Key] = 0
+		if rs != nil {
+			go rs.reconcile(context.Background())
 		}
 
-		offset := uncommitted.offsets[body.Key]
+		go func() {
+			ticker := time.NewTicker(expireStaleInterval)
+			defer ticker.Stop()
 
-		uncommitted.msgs[body.Key][offset] = body.Msg
+			for range ticker.C {
+				grp.ExpireStale()
+			}
+		}()
 
-		err := node.Reply(msg, SendOkBody{
-			Type:   SendOkType,
-			Offset: offset,
-		})
+		return nil
+	})
 
-		if err != nil {
+	node.Handle(SendType, func(msg maelstrom.Message) error {
+		var body SendBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
 			return err
 		}
 
-		offset++
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), SendType)
+		defer span.End()
 
-		uncommitted.offsets[body.Key] = offset
+		span.SetAttributes(
+			attribute.String("key", body.Key),
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+		)
 
-		return nil
+		offset, err := store.Send(ctx, body.Key, body.Msg)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		span.SetAttributes(attribute.Int("offset", offset))
+		links.Record(body.Key, offset, trace.SpanContextFromContext(ctx))
+		waiter.Broadcast(body.Key)
+
+		return node.Reply(msg, SendOkBody{
+			Type:   SendOkType,
+			Offset: offset,
+			Trace:  tracing.Inject(ctx),
+		})
 	})
 
 	node.Handle(PollType, func(msg maelstrom.Message) error {
@@ -212,79 +537,166 @@ func main() {
 			return err
 		}
 
-		msgs := make(map[string][][]int)
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), PollType)
+		defer span.End()
 
-		uncommitted.mutex.RLock()
-		defer uncommitted.mutex.RUnlock()
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+		)
 
-		for key, requestedOffset := range body.Offsets {
-			keyMsgs, ok := uncommitted.msgs[key]
+		msgs := store.Poll(body.Offsets, body.MaxBytes, body.MaxMsgsPerKey)
 
-			if !ok {
-				continue
+		if len(msgs) == 0 && body.MaxWaitMs > 0 {
+			keys := make([]string, 0, len(body.Offsets))
+			for key := range body.Offsets {
+				keys = append(keys, key)
 			}
 
-			offsets := []int{}
+			waiter.Wait(keys, time.Duration(body.MaxWaitMs)*time.Millisecond)
+
+			msgs = store.Poll(body.Offsets, body.MaxBytes, body.MaxMsgsPerKey)
+		}
+
+		var spanLinks []trace.Link
 
-			for offset := range keyMsgs {
-				if offset >= requestedOffset {
-					offsets = append(offsets, offset)
+		for key, keyMsgs := range msgs {
+			for _, offsetAndMsg := range keyMsgs {
+				if sc, ok := links.Lookup(key, offsetAndMsg[0]); ok {
+					spanLinks = append(spanLinks, trace.Link{SpanContext: sc})
 				}
 			}
+		}
 
-			sort.Ints(offsets)
+		if len(spanLinks) > 0 {
+			// Links can only be attached at span creation, and which sends
+			// fed this reply isn't known until store.Poll returns above, so
+			// the link back to each send gets its own short-lived child span
+			// nested under the poll span, rather than the poll span itself.
+			_, linkSpan := tracing.Tracer().Start(ctx, PollType+".sends", trace.WithLinks(spanLinks...))
+			linkSpan.End()
+		}
 
-			for _, offset := range offsets {
-				if msg, ok := keyMsgs[offset]; ok {
-					msgs[key] = append(msgs[key], []int{offset, msg})
-				}
+		reply := PollOkBody{
+			Type:         PollOkType,
+			GenerationId: grp.Generation(),
+			Trace:        tracing.Inject(ctx),
+		}
+
+		c := codecCap.Codec()
+		if c.Name() == codec.None {
+			reply.Msgs = msgs
+		} else {
+			raw, err := json.Marshal(msgs)
+			if err != nil {
+				return err
+			}
+
+			blob, err := c.Encode(raw)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return err
 			}
+
+			reply.Codec = c.Name()
+			reply.Blob = blob
 		}
 
-		return node.Reply(msg, PollOkBody{
-			Type: PollOkType,
-			Msgs: msgs,
-		})
+		return node.Reply(msg, reply)
 	})
 
-	node.Handle(CommitOffsetsType, func(msg maelstrom.Message) error {
-		var body CommitOffsetsBody
+	node.Handle(AddBatchType, func(msg maelstrom.Message) error {
+		var body AddBatchBody
 
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
 			return err
 		}
 
-		uncommitted.mutex.RLock()
-		defer uncommitted.mutex.RUnlock()
+		entries := body.Msgs
 
-		committed.mutex.Lock()
-		defer committed.mutex.Unlock()
+		if len(body.Blob) > 0 {
+			c, err := codec.ByName(body.Codec)
+			if err != nil {
+				return err
+			}
 
-		for key, requestedOffset := range body.Offsets {
-			uncommittedKeyMsgs, ok := uncommitted.msgs[key]
+			raw, err := c.Decode(body.Blob)
+			if err != nil {
+				return fmt.Errorf("add_batch: decode %s blob: %w", body.Codec, err)
+			}
 
-			if !ok {
-				continue
+			if err := json.Unmarshal(raw, &entries); err != nil {
+				return fmt.Errorf("add_batch: unmarshal decoded msgs: %w", err)
 			}
+		}
 
-			for offset, uncommittedMsg := range uncommittedKeyMsgs {
-				// Commit only until requested offset
-				if offset > requestedOffset {
-					continue
-				}
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), AddBatchType)
+		defer span.End()
 
-				if _, ok := committed.msgs[key]; !ok {
-					committed.msgs[key] = make(map[int]int)
-				}
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+			attribute.Int("batch.size", len(entries)),
+		)
+
+		offsets := make([]int, len(entries))
+		broadcast := make(map[string]bool, len(entries))
 
-				committed.msgs[key][offset] = uncommittedMsg
+		for i, entry := range entries {
+			offset, err := store.Send(ctx, entry.Key, entry.Msg)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return err
 			}
 
-			committed.offsets[key] = requestedOffset
+			offsets[i] = offset
+			links.Record(entry.Key, offset, trace.SpanContextFromContext(ctx))
+			broadcast[entry.Key] = true
 		}
 
+		// Wake each distinct key's long-pollers once the whole batch has
+		// landed, instead of once per entry.
+		for key := range broadcast {
+			waiter.Broadcast(key)
+		}
+
+		return node.Reply(msg, AddBatchOkBody{
+			Type:    AddBatchOkType,
+			Offsets: offsets,
+			Trace:   tracing.Inject(ctx),
+		})
+	})
+
+	node.Handle(CommitOffsetsType, func(msg maelstrom.Message) error {
+		var body CommitOffsetsBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), CommitOffsetsType)
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+			attribute.Int("generation_id", body.GenerationId),
+		)
+
+		if !grp.CheckCommit(body.GenerationId) {
+			err := maelstrom.NewRPCError(maelstrom.PreconditionFailed, "commit_offsets: stale generation_id, rejoin the group")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		store.CommitOffsets(body.Offsets)
+
 		return node.Reply(msg, CommitOffsetsOkBody{
-			Type: CommitOffsetsOkType,
+			Type:  CommitOffsetsOkType,
+			Trace: tracing.Inject(ctx),
 		})
 	})
 
@@ -295,24 +707,143 @@ func main() {
 			return err
 		}
 
-		offsets := make(map[string]int)
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), ListCommittedOffsetsType)
+		defer span.End()
 
-		committed.mutex.RLock()
-		defer committed.mutex.RUnlock()
-
-		for _, key := range body.Keys {
-			if offset, ok := committed.offsets[key]; ok {
-				offsets[key] = offset
-			}
-		}
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+		)
 
 		return node.Reply(msg, ListCommittedOffsetsOkBody{
 			Type:    ListCommittedOffsetsOkType,
-			Offsets: offsets,
+			Offsets: store.ListCommittedOffsets(body.Keys),
+			Trace:   tracing.Inject(ctx),
+		})
+	})
+
+	node.Handle(JoinGroupType, func(msg maelstrom.Message) error {
+		var body JoinGroupBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), JoinGroupType)
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+			attribute.String("member_id", body.MemberId),
+		)
+
+		generationID := grp.Join(body.MemberId, body.Keys)
+
+		span.SetAttributes(attribute.Int("generation_id", generationID))
+
+		return node.Reply(msg, JoinGroupOkBody{
+			Type:         JoinGroupOkType,
+			GenerationId: generationID,
+			Trace:        tracing.Inject(ctx),
+		})
+	})
+
+	node.Handle(SyncGroupType, func(msg maelstrom.Message) error {
+		var body SyncGroupBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), SyncGroupType)
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+			attribute.String("member_id", body.MemberId),
+			attribute.Int("generation_id", body.GenerationId),
+		)
+
+		keys, ok := grp.Sync(body.MemberId, body.GenerationId)
+		if !ok {
+			err := maelstrom.NewRPCError(maelstrom.PreconditionFailed, "sync_group: stale generation_id, rejoin the group")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		return node.Reply(msg, SyncGroupOkBody{
+			Type:  SyncGroupOkType,
+			Keys:  keys,
+			Trace: tracing.Inject(ctx),
+		})
+	})
+
+	node.Handle(HeartbeatType, func(msg maelstrom.Message) error {
+		var body HeartbeatBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), HeartbeatType)
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+			attribute.String("member_id", body.MemberId),
+			attribute.Int("generation_id", body.GenerationId),
+		)
+
+		if !grp.Heartbeat(body.MemberId, body.GenerationId) {
+			err := maelstrom.NewRPCError(maelstrom.PreconditionFailed, "heartbeat: stale generation_id, rejoin the group")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		return node.Reply(msg, HeartbeatOkBody{
+			Type:  HeartbeatOkType,
+			Trace: tracing.Inject(ctx),
 		})
 	})
 
-	if err := node.Run(); err != nil {
-		log.Fatal(err)
+	node.Handle(LeaveGroupType, func(msg maelstrom.Message) error {
+		var body LeaveGroupBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), body.Trace), LeaveGroupType)
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("msg.src", msg.Src),
+			attribute.String("msg.dest", msg.Dest),
+			attribute.String("member_id", body.MemberId),
+		)
+
+		grp.Leave(body.MemberId)
+
+		return node.Reply(msg, LeaveGroupOkBody{
+			Type:  LeaveGroupOkType,
+			Trace: tracing.Inject(ctx),
+		})
+	})
+
+	runErr := node.Run()
+
+	if tracingShutdown != nil {
+		if err := tracingShutdown(context.Background()); err != nil {
+			log.Printf("tracing: shutdown: %v", err)
+		}
+	}
+
+	if runErr != nil {
+		log.Fatal(runErr)
 	}
 }
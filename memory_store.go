@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/thtg88/maelstrom-kafka/commitlog"
+)
+
+// approxEntryBytes is the assumed wire size of a single (offset, msg) pair,
+// used to enforce Poll's maxBytes budget in memory. It's the same
+// commitlog.RecordBytes diskStore enforces its own maxBytes budget against,
+// so the same max_bytes value means the same thing against either Store
+// backend.
+const approxEntryBytes = commitlog.RecordBytes
+
+// entry is a single offset-assigned message.
+type entry struct {
+	Offset int
+	Msg    int
+}
+
+// logs holds the offset-assigned messages for a set of keys, guarded by a
+// single mutex. memoryStore keeps one logs for uncommitted messages and one
+// for committed messages. Each key's entries are kept sorted by offset so
+// Poll can binary-search for its start point instead of scanning and
+// sorting the whole key on every call.
+type logs struct {
+	mutex sync.RWMutex
+
+	// {
+	// 	"k1": 1000,
+	// 	"k2": 2000
+	// }
+	offsets map[string]int
+
+	// {
+	// 	"k1": [{0, 1}, {1, 123}, {2, 12}],
+	// 	"k2": [{0, 3}, {1, 456}, {2, 45}]
+	// }
+	msgs map[string][]entry
+}
+
+// memoryStore is the original single-node Store backend: everything lives in
+// memory and is lost on restart. It also serves as the local log for
+// replicatedStore, which assigns offsets out-of-band and calls observe
+// instead of Send.
+type memoryStore struct {
+	committed   logs
+	uncommitted logs
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		committed: logs{
+			offsets: make(map[string]int),
+			msgs:    make(map[string][]entry),
+		},
+		uncommitted: logs{
+			offsets: make(map[string]int),
+			msgs:    make(map[string][]entry),
+		},
+	}
+}
+
+func (s *memoryStore) Send(_ context.Context, key string, msg int) (int, error) {
+	s.uncommitted.mutex.Lock()
+	defer s.uncommitted.mutex.Unlock()
+
+	offset := s.uncommitted.offsets[key]
+
+	// Send always assigns the next offset, so the entry belongs at the end
+	// of the slice and no search is needed to keep it sorted.
+	s.uncommitted.msgs[key] = append(s.uncommitted.msgs[key], entry{Offset: offset, Msg: msg})
+	s.uncommitted.offsets[key] = offset + 1
+
+	return offset, nil
+}
+
+// observe records msg at a specific, already-assigned offset, as reported by
+// a peer's gossip or by reconciliation. Unlike Send, the offset may arrive
+// out of order or be a duplicate, so it's upserted into its sorted position
+// instead of appended. The key's next offset is bumped past offset if
+// necessary so that entries observed out of order don't collide with
+// offsets this node later assigns itself.
+func (s *memoryStore) observe(key string, offset, msg int) {
+	s.uncommitted.mutex.Lock()
+	defer s.uncommitted.mutex.Unlock()
+
+	s.uncommitted.msgs[key] = upsertEntry(s.uncommitted.msgs[key], offset, msg)
+
+	if next := offset + 1; next > s.uncommitted.offsets[key] {
+		s.uncommitted.offsets[key] = next
+	}
+}
+
+// upsertEntry inserts (offset, msg) into entries, which must already be
+// sorted by offset, overwriting the existing entry for offset if there is
+// one.
+func upsertEntry(entries []entry, offset, msg int) []entry {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Offset >= offset })
+
+	if i < len(entries) && entries[i].Offset == offset {
+		entries[i].Msg = msg
+		return entries
+	}
+
+	entries = append(entries, entry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = entry{Offset: offset, Msg: msg}
+
+	return entries
+}
+
+// nextOffset returns the offset that would be assigned to the next message
+// sent for key, i.e. one past the highest offset this node has observed.
+func (s *memoryStore) nextOffset(key string) int {
+	s.uncommitted.mutex.RLock()
+	defer s.uncommitted.mutex.RUnlock()
+
+	return s.uncommitted.offsets[key]
+}
+
+// keys returns every key this node has observed at least one message for.
+func (s *memoryStore) keys() []string {
+	s.uncommitted.mutex.RLock()
+	defer s.uncommitted.mutex.RUnlock()
+
+	keys := make([]string, 0, len(s.uncommitted.msgs))
+	for key := range s.uncommitted.msgs {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Poll returns entries at or above the requested offset for each key, via a
+// binary search into each key's sorted slice instead of a full scan. It
+// stops accumulating entries for a key once maxMsgsPerKey entries have been
+// returned or maxBytes worth of entries (assuming approxEntryBytes each)
+// have been returned across the whole call, whichever comes first; either
+// limit is ignored if non-positive.
+func (s *memoryStore) Poll(offsets map[string]int, maxBytes, maxMsgsPerKey int) map[string][][]int {
+	msgs := make(map[string][][]int)
+
+	s.uncommitted.mutex.RLock()
+	defer s.uncommitted.mutex.RUnlock()
+
+	remainingBytes := maxBytes
+
+	for key, requestedOffset := range offsets {
+		keyEntries := s.uncommitted.msgs[key]
+
+		start := sort.Search(len(keyEntries), func(i int) bool { return keyEntries[i].Offset >= requestedOffset })
+
+		for _, e := range keyEntries[start:] {
+			if maxMsgsPerKey > 0 && len(msgs[key]) >= maxMsgsPerKey {
+				break
+			}
+
+			if maxBytes > 0 && remainingBytes <= 0 {
+				break
+			}
+
+			msgs[key] = append(msgs[key], []int{e.Offset, e.Msg})
+			remainingBytes -= approxEntryBytes
+		}
+	}
+
+	return msgs
+}
+
+func (s *memoryStore) CommitOffsets(offsets map[string]int) {
+	s.uncommitted.mutex.RLock()
+	defer s.uncommitted.mutex.RUnlock()
+
+	s.committed.mutex.Lock()
+	defer s.committed.mutex.Unlock()
+
+	for key, requestedOffset := range offsets {
+		uncommittedKeyEntries, ok := s.uncommitted.msgs[key]
+		if !ok {
+			continue
+		}
+
+		// Everything up to and including requestedOffset is a prefix of the
+		// sorted slice, so it can be copied in one slice op instead of a
+		// filtering scan.
+		end := sort.Search(len(uncommittedKeyEntries), func(i int) bool {
+			return uncommittedKeyEntries[i].Offset > requestedOffset
+		})
+
+		if end > 0 {
+			s.committed.msgs[key] = append([]entry(nil), uncommittedKeyEntries[:end]...)
+		}
+
+		s.committed.offsets[key] = requestedOffset
+	}
+}
+
+func (s *memoryStore) ListCommittedOffsets(keys []string) map[string]int {
+	offsets := make(map[string]int)
+
+	s.committed.mutex.RLock()
+	defer s.committed.mutex.RUnlock()
+
+	for _, key := range keys {
+		if offset, ok := s.committed.offsets[key]; ok {
+			offsets[key] = offset
+		}
+	}
+
+	return offsets
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxOffsetLinks bounds how many (key, offset) span contexts offsetLinks
+// remembers at once, evicting the oldest first once the cache is full. The
+// link is only useful while a poll is likely to observe the entry soon
+// after send records it, so the cache doesn't need to grow without bound to
+// do its job.
+const maxOffsetLinks = 100_000
+
+// offsetLinks remembers the span context of the send that produced each
+// (key, offset) pair, so that a later poll returning that entry can record a
+// span link back to it. This is what lets Jaeger show send and the poll that
+// eventually observes it as part of the same causal chain, even though they
+// arrive as two unrelated Maelstrom messages.
+type offsetLinks struct {
+	mutex sync.Mutex
+	spans map[string]trace.SpanContext
+	order []string // insertion order of spans' keys, oldest first
+}
+
+func newOffsetLinks() *offsetLinks {
+	return &offsetLinks{spans: make(map[string]trace.SpanContext)}
+}
+
+func offsetLinkKey(key string, offset int) string {
+	return fmt.Sprintf("%s:%d", key, offset)
+}
+
+// Record stores the span context for the send that assigned offset to key,
+// evicting the oldest recorded entry if the cache is at maxOffsetLinks.
+func (l *offsetLinks) Record(key string, offset int, sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	linkKey := offsetLinkKey(key, offset)
+
+	if _, exists := l.spans[linkKey]; !exists {
+		l.order = append(l.order, linkKey)
+	}
+
+	l.spans[linkKey] = sc
+
+	for len(l.order) > maxOffsetLinks {
+		oldest := l.order[0]
+		l.order = l.order[1:]
+		delete(l.spans, oldest)
+	}
+}
+
+// Lookup returns the span context recorded for (key, offset), if any.
+func (l *offsetLinks) Lookup(key string, offset int) (trace.SpanContext, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	sc, ok := l.spans[offsetLinkKey(key, offset)]
+	return sc, ok
+}
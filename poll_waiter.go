@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pollWaiter lets poll block until send appends new data for one of the
+// keys it's waiting on, Kafka-style long polling, instead of either
+// busy-waiting or returning empty-handed the instant nothing is ready yet.
+type pollWaiter struct {
+	mutex sync.Mutex
+	conds map[string]*sync.Cond
+}
+
+func newPollWaiter() *pollWaiter {
+	return &pollWaiter{conds: make(map[string]*sync.Cond)}
+}
+
+// condFor returns the condition variable for key, creating it on first use.
+// Every cond shares w.mutex as its Locker, which sync.Cond allows; it's
+// still only ever woken by a Broadcast naming that specific key.
+func (w *pollWaiter) condFor(key string) *sync.Cond {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	c, ok := w.conds[key]
+	if !ok {
+		c = sync.NewCond(&w.mutex)
+		w.conds[key] = c
+	}
+
+	return c
+}
+
+// Broadcast wakes every poll blocked waiting on key. send calls this right
+// after appending a new message.
+func (w *pollWaiter) Broadcast(key string) {
+	w.condFor(key).Broadcast()
+}
+
+// Wait blocks until some other goroutine calls Broadcast for one of keys,
+// or until timeout elapses, whichever comes first. A send landing in the
+// narrow window between the caller's last Poll and the cond.Wait below is
+// not observed until timeout, the same trade-off Kafka's own long-poll
+// makes in exchange for not holding a lock across the whole wait.
+func (w *pollWaiter) Wait(keys []string, timeout time.Duration) {
+	woken := make(chan struct{}, 1)
+
+	for _, key := range keys {
+		key := key
+
+		go func() {
+			c := w.condFor(key)
+
+			w.mutex.Lock()
+			c.Wait()
+			w.mutex.Unlock()
+
+			select {
+			case woken <- struct{}{}:
+			default:
+			}
+		}()
+	}
+
+	select {
+	case <-woken:
+	case <-time.After(timeout):
+	}
+
+	// Release every goroutine still parked above so none of them leaks past
+	// this call once the caller has moved on.
+	for _, key := range keys {
+		w.condFor(key).Broadcast()
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	// ReplicateType is gossiped fire-and-forget to every peer whenever a
+	// message is appended, so that poll on any node observes the same log
+	// regardless of which node the client's send landed on.
+	ReplicateType = "replicate"
+
+	// ListKeysType/ListKeysOkType are used internally during reconciliation
+	// to discover which keys a peer knows about.
+	ListKeysType   = "list_keys"
+	ListKeysOkType = "list_keys_ok"
+)
+
+// nextOffsetKey is the lin-kv counter key used to atomically allocate the
+// next offset for a given log key.
+func nextOffsetKey(key string) string {
+	return fmt.Sprintf("next-offset:%s", key)
+}
+
+type replicateBody struct {
+	Type   string `json:"type"`
+	Key    string `json:"key"`
+	Offset int    `json:"offset"`
+	Msg    int    `json:"msg"`
+}
+
+type listKeysOkBody struct {
+	Type string   `json:"type"`
+	Keys []string `json:"keys"`
+}
+
+// replicatedStore is the multi-node Store backend. Offsets are allocated by
+// running a compare-and-swap loop against a linearizable "next-offset:<key>"
+// counter in Maelstrom's lin-kv service, so that every node agrees on the
+// offset for a given send even though the request may land on any of them.
+// The resulting (key, offset, msg) tuple is then gossiped to every peer so
+// poll converges on all nodes.
+type replicatedStore struct {
+	node  *maelstrom.Node
+	kv    *maelstrom.KV
+	local *memoryStore
+}
+
+// newReplicatedStore returns a replicatedStore and registers the internal
+// RPC handlers it needs from peers. The caller is still responsible for
+// calling reconcile once the node has finished its init handshake.
+func newReplicatedStore(node *maelstrom.Node) *replicatedStore {
+	s := &replicatedStore{
+		node:  node,
+		kv:    maelstrom.NewLinKV(node),
+		local: newMemoryStore(),
+	}
+
+	node.Handle(ReplicateType, s.handleReplicate)
+
+	node.Handle(ListKeysType, func(msg maelstrom.Message) error {
+		return node.Reply(msg, listKeysOkBody{
+			Type: ListKeysOkType,
+			Keys: s.local.keys(),
+		})
+	})
+
+	return s
+}
+
+func (s *replicatedStore) handleReplicate(msg maelstrom.Message) error {
+	var body replicateBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	s.local.observe(body.Key, body.Offset, body.Msg)
+
+	return nil
+}
+
+func (s *replicatedStore) Send(ctx context.Context, key string, msg int) (int, error) {
+	counterKey := nextOffsetKey(key)
+
+	for {
+		offset, err := s.kv.ReadInt(ctx, counterKey)
+		if err != nil {
+			if maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+				return 0, err
+			}
+			offset = 0
+		}
+
+		if err := s.kv.CompareAndSwap(ctx, counterKey, offset, offset+1, true); err != nil {
+			if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+				continue // another node won the race; re-read and retry
+			}
+			return 0, err
+		}
+
+		s.local.observe(key, offset, msg)
+		s.gossip(key, offset, msg)
+
+		return offset, nil
+	}
+}
+
+// gossip fans the newly assigned (key, offset, msg) tuple out to every other
+// node. It is fire-and-forget: a node that misses a gossip message picks it
+// up later via reconcile.
+func (s *replicatedStore) gossip(key string, offset, msg int) {
+	body := replicateBody{Type: ReplicateType, Key: key, Offset: offset, Msg: msg}
+
+	for _, dest := range s.node.NodeIDs() {
+		if dest == s.node.ID() {
+			continue
+		}
+
+		if err := s.node.Send(dest, body); err != nil {
+			log.Printf("gossip %s@%d to %s: %s", key, offset, dest, err)
+		}
+	}
+}
+
+// reconcile pulls any messages this node missed - for example because it was
+// offline when they were gossiped - by asking every peer which keys it knows
+// about and polling each one from the offset this node has already observed.
+// It is meant to run once, in the background, after the node has finished
+// its init handshake.
+func (s *replicatedStore) reconcile(ctx context.Context) {
+	for _, dest := range s.node.NodeIDs() {
+		if dest == s.node.ID() {
+			continue
+		}
+
+		if err := s.reconcileFrom(ctx, dest); err != nil {
+			log.Printf("reconcile from %s: %s", dest, err)
+		}
+	}
+}
+
+func (s *replicatedStore) reconcileFrom(ctx context.Context, dest string) error {
+	keysMsg, err := s.node.SyncRPC(ctx, dest, map[string]string{"type": ListKeysType})
+	if err != nil {
+		return err
+	}
+
+	var keysBody listKeysOkBody
+	if err := json.Unmarshal(keysMsg.Body, &keysBody); err != nil {
+		return err
+	}
+
+	if len(keysBody.Keys) == 0 {
+		return nil
+	}
+
+	offsets := make(map[string]int, len(keysBody.Keys))
+	for _, key := range keysBody.Keys {
+		offsets[key] = s.local.nextOffset(key)
+	}
+
+	pollMsg, err := s.node.SyncRPC(ctx, dest, PollBody{Type: PollType, Offsets: offsets})
+	if err != nil {
+		return err
+	}
+
+	var pollBody PollOkBody
+	if err := json.Unmarshal(pollMsg.Body, &pollBody); err != nil {
+		return err
+	}
+
+	msgs, err := pollBody.Decode()
+	if err != nil {
+		return err
+	}
+
+	for key, entries := range msgs {
+		for _, entry := range entries {
+			s.local.observe(key, entry[0], entry[1])
+		}
+	}
+
+	return nil
+}
+
+func (s *replicatedStore) Poll(offsets map[string]int, maxBytes, maxMsgsPerKey int) map[string][][]int {
+	return s.local.Poll(offsets, maxBytes, maxMsgsPerKey)
+}
+
+func (s *replicatedStore) CommitOffsets(offsets map[string]int) {
+	s.local.CommitOffsets(offsets)
+}
+
+func (s *replicatedStore) ListCommittedOffsets(keys []string) map[string]int {
+	return s.local.ListCommittedOffsets(keys)
+}
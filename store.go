@@ -0,0 +1,28 @@
+package main
+
+import "context"
+
+// Store abstracts the persistence and replication strategy behind the four
+// RPC handlers. main wires a single Store implementation at startup so that
+// the single-node in-memory backend and the replicated, lin-kv-backed
+// backend can be swapped in without touching the handler logic.
+type Store interface {
+	// Send appends msg to the log for key and returns the offset it was
+	// assigned.
+	Send(ctx context.Context, key string, msg int) (int, error)
+
+	// Poll returns messages with an offset at or above the requested offset,
+	// for each requested key. maxBytes caps the (approximate) number of
+	// bytes of entries returned across the whole call and maxMsgsPerKey caps
+	// the number of entries returned per key, whichever is hit first; either
+	// limit is ignored if non-positive.
+	Poll(offsets map[string]int, maxBytes, maxMsgsPerKey int) map[string][][]int
+
+	// CommitOffsets records that messages up to and including the given
+	// offset have been processed for each key.
+	CommitOffsets(offsets map[string]int)
+
+	// ListCommittedOffsets returns the committed offset for each requested
+	// key that has one.
+	ListCommittedOffsets(keys []string) map[string]int
+}
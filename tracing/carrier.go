@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Carrier is the wire representation of a trace context. It is embedded as
+// the "trace" field of an RPC body so trace context survives the hop from
+// node to node (and from node back to client and back again) instead of
+// being confined to a single process.
+type Carrier map[string]string
+
+func (c Carrier) Get(key string) string { return c[key] }
+
+func (c Carrier) Set(key, value string) { c[key] = value }
+
+func (c Carrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Inject encodes ctx's current span context into a Carrier suitable for
+// embedding in an outgoing RPC body.
+func Inject(ctx context.Context) Carrier {
+	carrier := make(Carrier)
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract returns a context carrying the span context encoded in carrier, so
+// a handler's span can be a child of whichever span produced the incoming
+// request. A nil or empty carrier returns ctx unchanged.
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// SpanContext returns the trace.SpanContext encoded in carrier, for use as a
+// span.Link back to whichever span produced it. The returned SpanContext is
+// invalid (IsValid() == false) if carrier is empty or malformed.
+func SpanContext(carrier Carrier) trace.SpanContext {
+	return trace.SpanContextFromContext(Extract(context.Background(), carrier))
+}
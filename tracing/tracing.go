@@ -0,0 +1,64 @@
+// Package tracing wires up OpenTelemetry so every RPC handler emits a span,
+// and carries trace context across the wire in the RPC bodies themselves so
+// that a send and the poll that later returns it show up as one trace.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies this package's spans in the emitted traces.
+const tracerName = "github.com/thtg88/maelstrom-kafka"
+
+// Enabled reports whether an OTLP collector endpoint has been configured via
+// the standard OTEL_EXPORTER_OTLP_ENDPOINT / OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+// env vars. When false, Setup is skipped and handlers run without tracing.
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup configures the global TracerProvider to export spans over OTLP/gRPC,
+// tagging every span with nodeID as its service instance. The exporter
+// itself is configured entirely from the standard OTEL_EXPORTER_OTLP_* env
+// vars (endpoint, headers, TLS, ...), which is how operators point it at a
+// collector feeding Jaeger. The returned shutdown func flushes and closes
+// the exporter and should be called before the process exits.
+func Setup(ctx context.Context, nodeID string) (shutdown func(context.Context) error, err error) {
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceNameKey.String("maelstrom-kafka"),
+		semconv.ServiceInstanceIDKey.String(nodeID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: build resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer RPC handlers should use to start their spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}